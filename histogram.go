@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// histogramBucketsPerDecade is the number of equal-width linear
+// buckets each decade (10x range of values) is split into. Because
+// the buckets are linear rather than log-uniform, relative error
+// varies across a decade: at 90 buckets the half-bucket error is
+// about 5% at a decade's low edge (e.g. 10ms in the 10ms-100ms
+// decade) tightening to about 0.5% near its high edge (e.g. 99ms).
+// This is coarser than a true HdrHistogram, which keeps relative
+// error uniform, but is simple and good enough for a stress tester's
+// reporting.
+const histogramBucketsPerDecade = 90
+
+// latencyHistogram is a fixed-memory, log-linear latency histogram in
+// the spirit of HdrHistogram: recording and quantile lookups are both
+// O(buckets), not O(requests), which matters once -r climbs into the
+// millions. Individual samples are not retained; see -keep-raw on
+// benchmarkStats for that.
+type latencyHistogram struct {
+	lowestTrackable  time.Duration
+	highestTrackable time.Duration
+	decadeCount      int
+	counts           []int64
+	totalCount       int64
+	sum              time.Duration
+	min              time.Duration
+	max              time.Duration
+}
+
+func newLatencyHistogram(lowest, highest time.Duration) *latencyHistogram {
+	// decadeCount must match the highest decade bucketIndex can ever
+	// land on: it clamps values above highest down to highest itself
+	// before computing a decade, so a decade past that point would be
+	// allocated but unreachable.
+	decadeCount := int(math.Log10(float64(highest)/float64(lowest))) + 1
+	if decadeCount < 1 {
+		decadeCount = 1
+	}
+	return &latencyHistogram{
+		lowestTrackable:  lowest,
+		highestTrackable: highest,
+		decadeCount:      decadeCount,
+		counts:           make([]int64, decadeCount*histogramBucketsPerDecade),
+	}
+}
+
+func (h *latencyHistogram) decadeBounds(decade int) (start, end float64) {
+	start = float64(h.lowestTrackable) * math.Pow(10, float64(decade))
+	return start, start * 10
+}
+
+// bucketIndex maps a duration to its bucket, clamping out-of-range
+// values rather than panicking: values below lowestTrackable land in
+// the first bucket, values above highestTrackable in the bucket of
+// highestTrackable itself.
+func (h *latencyHistogram) bucketIndex(value time.Duration) int {
+	if value < h.lowestTrackable {
+		value = h.lowestTrackable
+	}
+	if value > h.highestTrackable {
+		value = h.highestTrackable
+	}
+
+	decade := int(math.Log10(float64(value) / float64(h.lowestTrackable)))
+	if decade >= h.decadeCount {
+		decade = h.decadeCount - 1
+	}
+	start, end := h.decadeBounds(decade)
+	step := (end - start) / float64(histogramBucketsPerDecade)
+	offset := int((float64(value) - start) / step)
+	if offset >= histogramBucketsPerDecade {
+		offset = histogramBucketsPerDecade - 1
+	}
+	return decade*histogramBucketsPerDecade + offset
+}
+
+// bucketDuration returns the midpoint duration represented by a bucket.
+func (h *latencyHistogram) bucketDuration(index int) time.Duration {
+	decade := index / histogramBucketsPerDecade
+	offset := index % histogramBucketsPerDecade
+	start, end := h.decadeBounds(decade)
+	step := (end - start) / float64(histogramBucketsPerDecade)
+	return time.Duration(start + step*(float64(offset)+0.5))
+}
+
+func (h *latencyHistogram) record(value time.Duration) {
+	h.counts[h.bucketIndex(value)]++
+	h.totalCount++
+	h.sum += value
+	if h.totalCount == 1 || value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+}
+
+func (h *latencyHistogram) mean() time.Duration {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return time.Duration(int64(h.sum) / h.totalCount)
+}
+
+// quantile returns the midpoint duration of the bucket holding the
+// sample at the given quantile (0 < q <= 1), walking the bucket
+// cumulative counts.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.totalCount == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(q * float64(h.totalCount)))
+	if target < 1 {
+		target = 1
+	}
+	var cumulative int64
+	for i, c := range h.counts {
+		cumulative += c
+		if cumulative >= target {
+			return h.bucketDuration(i)
+		}
+	}
+	return h.max
+}
+
+// printASCII renders a one-line-per-bucket bar chart of the non-empty
+// buckets, scaled to the busiest bucket.
+func (h *latencyHistogram) printASCII() {
+	if h.totalCount == 0 {
+		return
+	}
+	var maxCount int64
+	for _, c := range h.counts {
+		if c > maxCount {
+			maxCount = c
+		}
+	}
+
+	const barWidth = 40
+	fmt.Println("Latency histogram:")
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		barLen := int(float64(c) / float64(maxCount) * barWidth)
+		if barLen < 1 {
+			barLen = 1
+		}
+		fmt.Printf("  %12s | %-*s %d\n", h.bucketDuration(i), barWidth, strings.Repeat("#", barLen), c)
+	}
+}
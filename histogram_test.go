@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestHistogram() *latencyHistogram {
+	return newLatencyHistogram(time.Millisecond, time.Second)
+}
+
+// withinBucket fails the test unless got is within tolerance of want;
+// callers pass a tolerance covering the bucket width, which is the
+// most the histogram's lossy recording can promise.
+func withinBucket(t *testing.T, h *latencyHistogram, got, want time.Duration, tolerance time.Duration) {
+	t.Helper()
+	diff := got - want
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > tolerance {
+		t.Errorf("got %s, want %s (±%s)", got, want, tolerance)
+	}
+}
+
+func TestQuantileKnownDistribution(t *testing.T) {
+	h := newTestHistogram()
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+
+	cases := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.95, 95 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+	}
+	for _, c := range cases {
+		got := h.quantile(c.q)
+		withinBucket(t, h, got, c.want, 2*time.Millisecond)
+	}
+}
+
+func TestQuantileEmpty(t *testing.T) {
+	h := newTestHistogram()
+	if got := h.quantile(0.50); got != 0 {
+		t.Errorf("quantile on empty histogram = %s, want 0", got)
+	}
+}
+
+func TestBucketIndexDecadeBoundary(t *testing.T) {
+	h := newTestHistogram()
+
+	// 10ms is the boundary between the 1ms-10ms and 10ms-100ms
+	// decades. A value just below it must land in the first decade's
+	// last bucket, and 10ms itself must land in the second decade's
+	// first bucket.
+	justBelow := h.bucketIndex(10*time.Millisecond - 1)
+	boundary := h.bucketIndex(10 * time.Millisecond)
+
+	if justBelow/histogramBucketsPerDecade != 0 {
+		t.Errorf("just-below-10ms landed in decade %d, want decade 0", justBelow/histogramBucketsPerDecade)
+	}
+	if boundary/histogramBucketsPerDecade != 1 {
+		t.Errorf("10ms landed in decade %d, want decade 1", boundary/histogramBucketsPerDecade)
+	}
+	if justBelow == boundary {
+		t.Errorf("just-below-10ms and 10ms landed in the same bucket %d", boundary)
+	}
+}
+
+func TestBucketIndexClampsOutOfRange(t *testing.T) {
+	h := newTestHistogram()
+
+	belowLowest := h.bucketIndex(time.Microsecond)
+	if belowLowest != 0 {
+		t.Errorf("value below lowestTrackable landed in bucket %d, want 0", belowLowest)
+	}
+
+	// Clamping maps any too-large value onto the same bucket as
+	// highestTrackable itself, rather than panicking or indexing past
+	// the end of counts.
+	wantBucket := h.bucketIndex(h.highestTrackable)
+	aboveHighest := h.bucketIndex(time.Hour)
+	if aboveHighest != wantBucket {
+		t.Errorf("value above highestTrackable landed in bucket %d, want %d (highestTrackable's bucket)", aboveHighest, wantBucket)
+	}
+	if aboveHighest < 0 || aboveHighest >= len(h.counts) {
+		t.Fatalf("bucketIndex(time.Hour) = %d out of range [0, %d)", aboveHighest, len(h.counts))
+	}
+
+	// record/min/max track the raw sample, not the clamped one used
+	// for bucketing.
+	h.record(time.Hour)
+	if h.max != time.Hour {
+		t.Errorf("max after recording an out-of-range sample = %s, want %s", h.max, time.Hour)
+	}
+}
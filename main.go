@@ -7,10 +7,38 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"runtime"
+	"runtime/pprof"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// headerFlags collects repeated -H "Key: Value" flags into an
+// http.Header.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid header %q, expected \"Key: Value\"", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+// histogramLowestTrackable and histogramHighestTrackable bound the
+// latency range tracked by benchmarkStats.histogram: anything outside
+// this range is clamped into the nearest edge bucket rather than
+// growing memory unboundedly.
+const (
+	histogramLowestTrackable  = time.Microsecond
+	histogramHighestTrackable = 60 * time.Second
+)
+
 type durationSlice []time.Duration
 
 func (p durationSlice) Len() int           { return len(p) }
@@ -21,24 +49,72 @@ type benchmarkStats struct {
 	requestsStarted int
 	// concurrent workers running in parallel will feed the
 	// results to a single collector trough this buffered channel.
-	resultChannel      chan *measurement
+	resultChannel chan *measurement
+	// mu guards every field recordResult touches, so reportProgress
+	// can take a Snapshot from another goroutine without racing the
+	// collector loop.
+	mu                 sync.Mutex
 	failedRequests     int
 	successfulRequests int
 	transferredBytes   int
-	durations          durationSlice
-	startedAt          time.Time
-	endedAt            time.Time
+	// histogram tracks the full latency distribution in O(buckets)
+	// memory. durations additionally holds every sample, but only
+	// when -keep-raw is set.
+	histogram *latencyHistogram
+	keepRaw   bool
+	durations durationSlice
+	startedAt time.Time
+	endedAt   time.Time
+	// dnsHistogram, connectHistogram, tlsHistogram and ttfbHistogram
+	// track the httptrace-derived phases behind the total latency
+	// recorded in histogram.
+	dnsHistogram     *latencyHistogram
+	connectHistogram *latencyHistogram
+	tlsHistogram     *latencyHistogram
+	ttfbHistogram    *latencyHistogram
+	// statusCounts and urlCounts are always tallied (cheap: bounded by
+	// the number of distinct codes/URLs seen) but only printed when
+	// breakdownByStatus / breakdownByURL is set.
+	statusCounts      map[int]int
+	urlCounts         map[string]int
+	breakdownByStatus bool
+	breakdownByURL    bool
+	// deadline is the wall-clock time the benchmark must stop at when
+	// run with -d. It is the zero time when the run is bound by -r
+	// instead.
+	deadline time.Time
+	// targetRPS is the requested pacing from -rps, or 0 when unthrottled.
+	targetRPS float64
+	// target, concurrencyLevel, repetitions and durationFlag are run
+	// parameters recorded purely for -resultFile; they don't affect
+	// how the benchmark executes.
+	target           string
+	concurrencyLevel int
+	repetitions      int
+	durationFlag     string
 }
 
 type measurement struct {
 	httpReplySize  int
 	httpStatusCode int
 	duration       time.Duration
+	url            string
+	timing         requestTiming
 }
 
-func NewBenchmarkStats(repetitions int, concurrencyLevel int) *benchmarkStats {
+func NewBenchmarkStats(repetitions int, concurrencyLevel int, keepRaw bool) *benchmarkStats {
 	bm := new(benchmarkStats)
-	bm.durations = make([]time.Duration, 0, repetitions)
+	bm.histogram = newLatencyHistogram(histogramLowestTrackable, histogramHighestTrackable)
+	bm.dnsHistogram = newLatencyHistogram(histogramLowestTrackable, histogramHighestTrackable)
+	bm.connectHistogram = newLatencyHistogram(histogramLowestTrackable, histogramHighestTrackable)
+	bm.tlsHistogram = newLatencyHistogram(histogramLowestTrackable, histogramHighestTrackable)
+	bm.ttfbHistogram = newLatencyHistogram(histogramLowestTrackable, histogramHighestTrackable)
+	bm.keepRaw = keepRaw
+	if keepRaw {
+		bm.durations = make(durationSlice, 0, repetitions)
+	}
+	bm.statusCounts = make(map[int]int)
+	bm.urlCounts = make(map[string]int)
 	bm.startedAt = time.Now()
 	bm.resultChannel = make(chan *measurement, concurrencyLevel)
 	return bm
@@ -48,14 +124,34 @@ func (bm *benchmarkStats) stop() {
 	bm.endedAt = time.Now()
 }
 
+// deadlineExceeded reports whether a -d deadline was set and has passed.
+// It is always false for repetition-bound runs.
+func (bm *benchmarkStats) deadlineExceeded() bool {
+	return !bm.deadline.IsZero() && time.Now().After(bm.deadline)
+}
+
 func (bm *benchmarkStats) recordResult(m *measurement) {
+	if bm.deadlineExceeded() {
+		return
+	}
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
 	if m.httpStatusCode >= 200 && m.httpStatusCode <= 299 {
 		bm.successfulRequests += 1
-		bm.durations = append(bm.durations, m.duration)
+		bm.histogram.record(m.duration)
+		bm.dnsHistogram.record(m.timing.dns)
+		bm.connectHistogram.record(m.timing.connect)
+		bm.tlsHistogram.record(m.timing.tls)
+		bm.ttfbHistogram.record(m.timing.ttfb)
+		if bm.keepRaw {
+			bm.durations = append(bm.durations, m.duration)
+		}
 	} else {
 		bm.failedRequests += 1
 	}
 	bm.transferredBytes += m.httpReplySize
+	bm.statusCounts[m.httpStatusCode]++
+	bm.urlCounts[m.url]++
 }
 
 func (bm *benchmarkStats) requestCount() int {
@@ -69,81 +165,75 @@ func (bm *benchmarkStats) printStats() {
 	elapsedTime := bm.elapsedTime()
 	fmt.Println("Kilobytes per second:", math.Floor(float64(bm.transferredBytes)/1024.0/elapsedTime.Seconds()))
 	fmt.Printf("Elapsed wall-clock time: %.2fs\n", elapsedTime.Seconds())
-	fmt.Printf("Slowest request: %.2fs\n", bm.slowestRequestDuration().Seconds())
-	fmt.Printf("Median request: %.2fs\n", bm.medianRequestDuration().Seconds())
-	fmt.Printf("Fastest request: %.2fs\n", bm.fastestRequestDuration().Seconds())
-	fmt.Printf("Average request: %.2fs\n", bm.averageRequestDuration().Seconds())
-	fmt.Printf("Standard deviation: %.2fs\n", bm.standardDeviation().Seconds())
-}
-
-func (bm *benchmarkStats) elapsedTime() time.Duration {
-	return bm.endedAt.Sub(bm.startedAt)
-}
-
-func (bm *benchmarkStats) totalTime() time.Duration {
-	var sum time.Duration
-	sum = 0
-	for _, value := range bm.durations {
-		sum += value
+	fmt.Printf("Achieved requests/sec: %.2f\n", bm.achievedRPS())
+	if bm.targetRPS > 0 {
+		fmt.Printf("Target requests/sec: %.2f\n", bm.targetRPS)
+	}
+	if bm.keepRaw {
+		fmt.Printf("Raw samples kept: %d\n", len(bm.durations))
 	}
-	return sum
-}
 
-func (bm *benchmarkStats) averageRequestDuration() time.Duration {
-	return time.Duration(math.Floor(float64(bm.totalTime().Nanoseconds()) / float64(len(bm.durations))))
-}
+	h := bm.histogram
+	fmt.Printf("Fastest request: %s\n", h.min)
+	fmt.Printf("Slowest request: %s\n", h.max)
+	fmt.Printf("Mean request: %s\n", h.mean())
+	fmt.Println("Latency percentiles:")
+	for _, q := range reportedQuantiles {
+		fmt.Printf("  %-6s %s\n", fmt.Sprintf("p%g", q*100), h.quantile(q))
+	}
+	h.printASCII()
+
+	fmt.Println("Connection timing (p50 / p95):")
+	for _, phase := range []struct {
+		label string
+		h     *latencyHistogram
+	}{
+		{"DNS", bm.dnsHistogram}, {"Connect", bm.connectHistogram},
+		{"TLS", bm.tlsHistogram}, {"TTFB", bm.ttfbHistogram},
+	} {
+		fmt.Printf("  %-8s %s / %s\n", phase.label, phase.h.quantile(0.50), phase.h.quantile(0.95))
+	}
 
-func (bm *benchmarkStats) slowestRequestDuration() time.Duration {
-	max := bm.durations[0]
-	for _, value := range bm.durations {
-		if max < value {
-			max = value
+	if bm.breakdownByStatus {
+		fmt.Println("Requests by status code:")
+		codes := make([]int, 0, len(bm.statusCounts))
+		for code := range bm.statusCounts {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Printf("  %d: %d\n", code, bm.statusCounts[code])
 		}
 	}
-	return max
-}
-
-func (bm *benchmarkStats) fastestRequestDuration() time.Duration {
-	min := bm.durations[0]
-	for _, value := range bm.durations {
-		if min > value {
-			min = value
+	if bm.breakdownByURL {
+		fmt.Println("Requests by URL:")
+		urls := make([]string, 0, len(bm.urlCounts))
+		for u := range bm.urlCounts {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		for _, u := range urls {
+			fmt.Printf("  %s: %d\n", u, bm.urlCounts[u])
 		}
 	}
-	return min
 }
 
-func (bm *benchmarkStats) medianRequestDuration() time.Duration {
-	length := bm.durations.Len()
-
-	durations := make(durationSlice, length)
-	copy(durations, bm.durations)
-	sort.Sort(durations)
-
-	return durations[int(length/2)]
+func (bm *benchmarkStats) achievedRPS() float64 {
+	return float64(bm.requestCount()) / bm.elapsedTime().Seconds()
 }
 
-func (bm *benchmarkStats) standardDeviation() time.Duration {
-	length := float64(bm.durations.Len())
-	mean := bm.averageRequestDuration().Nanoseconds()
-	sumDeltaSquared := 0.0
-	delta := 0.0
-	for _, value := range bm.durations {
-		delta = float64(mean - value.Nanoseconds())
-		sumDeltaSquared += (delta * delta)
-	}
-
-	variance := sumDeltaSquared / length
-	return time.Duration(math.Floor(math.Sqrt(variance)))
+func (bm *benchmarkStats) elapsedTime() time.Duration {
+	return bm.endedAt.Sub(bm.startedAt)
 }
 
-func (bm *benchmarkStats) measureUrl(url string) {
+func (bm *benchmarkStats) measure(requester Requester, pacer *requestPacer) {
 	bm.requestsStarted += 1
 	go func() {
+		pacer.wait()
 		t1 := time.Now()
-		status, size := retrieveUrl(url)
+		result := requester.Do()
 		t2 := time.Now()
-		bm.resultChannel <- &measurement{size, status, t2.Sub(t1)}
+		bm.resultChannel <- &measurement{result.replySize, result.statusCode, t2.Sub(t1), result.url, result.timing}
 	}()
 }
 
@@ -152,63 +242,235 @@ func (bm *benchmarkStats) receiveResult() {
 	bm.recordResult(result)
 }
 
-func retrieveUrl(url string) (int, int) {
-	resp, err := http.Get(url)
-	if err != nil {
-		fmt.Println("failed to fetch ", url)
-		return 500, 0
-	}
-
-	size := 0
-	for header, value := range resp.Header {
-		size += len(header) + len(value)
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("failed to read body ...")
-		return resp.StatusCode, size
+// receiveResultOrDeadline waits for a result the same way receiveResult
+// does, but also races deadlineTimer: with -timeout 0 (the default) a
+// hung request would otherwise block the collector loop past a -d
+// deadline indefinitely. It reports whether a result was actually
+// received.
+func (bm *benchmarkStats) receiveResultOrDeadline(deadlineTimer *time.Timer) bool {
+	select {
+	case result := <-bm.resultChannel:
+		bm.recordResult(result)
+		return true
+	case <-deadlineTimer.C:
+		return false
 	}
-
-	size += len(body)
-	return resp.StatusCode, size
 }
 
 func main() {
 	// parse command line arguments
 	var concurrencyLevel, repetitions int
+	var durationFlag string
+	var targetRPS float64
+	var keepRaw bool
+	var method, bodyFile, contentType, urlList string
+	var thinkTime time.Duration
+	var breakdownByStatus, breakdownByURL bool
+	var cpuProfileFile, memProfileFile, resultFile string
+	var requestTimeout time.Duration
+	var keepAlivesEnabled, http2Enabled, insecureSkipVerify bool
+	var maxIdleConnsPerHost int
+	var showProgress bool
+	headers := make(headerFlags)
 	flag.IntVar(&concurrencyLevel, "c", 5, "Concurrency level.")
 	flag.IntVar(&repetitions, "r", 300, "Number of requests to perform.")
+	flag.StringVar(&durationFlag, "d", "", "Run for this duration instead of a fixed repetition count (e.g. 30s, 5m). Overrides -r.")
+	flag.Float64Var(&targetRPS, "rps", 0, "Target requests per second across all workers (0 = unthrottled).")
+	flag.BoolVar(&keepRaw, "keep-raw", false, "Keep every raw sample in memory alongside the latency histogram, for debugging.")
+	flag.StringVar(&method, "X", "GET", "HTTP method to use.")
+	flag.StringVar(&bodyFile, "body", "", "Path to a file whose contents are sent as the request body (for -X POST/PUT).")
+	flag.StringVar(&contentType, "content-type", "", "Content-Type header to send along with -body.")
+	flag.StringVar(&urlList, "url-list", "", "Path to a weighted list of URLs to replay instead of a single URL (\"<weight> <url>\" per line, weight optional).")
+	flag.DurationVar(&thinkTime, "think", 0, "Pause this long before each request (simulated user think-time).")
+	flag.Var(&headers, "H", "Extra request header \"Key: Value\" (repeatable).")
+	flag.BoolVar(&breakdownByStatus, "by-status", false, "Break out request counts by HTTP status code in the report.")
+	flag.BoolVar(&breakdownByURL, "by-url", false, "Break out request counts by URL in the report.")
+	flag.StringVar(&cpuProfileFile, "cpuprofile", "", "Write a pprof CPU profile of the benchmark run to this path.")
+	flag.StringVar(&memProfileFile, "memprofile", "", "Write a pprof heap profile after the run to this path.")
+	flag.StringVar(&resultFile, "resultFile", "", "Write machine-readable results as JSON to this path, and a benchstat-compatible summary to this path plus \".bench\".")
+	flag.DurationVar(&requestTimeout, "timeout", 0, "Per-request timeout (0 = no timeout). Hung requests otherwise stall their worker forever.")
+	flag.BoolVar(&keepAlivesEnabled, "keepalive", true, "Reuse connections across requests. Set to false to force a cold connection per request.")
+	flag.BoolVar(&http2Enabled, "http2", true, "Allow negotiating HTTP/2 over TLS.")
+	flag.BoolVar(&insecureSkipVerify, "insecure", false, "Skip TLS certificate verification.")
+	flag.IntVar(&maxIdleConnsPerHost, "max-idle-conns-per-host", 0, "Idle connections kept per host (0 = match -c, so workers don't dial fresh connections).")
+	flag.BoolVar(&showProgress, "progress", stderrIsTerminal(), "Print a live one-line progress update to stderr once per second.")
 	flag.Parse()
 
-	// url is the first non flag argument. If none exists, print the usage and exit.
-	if len(flag.Args()) == 0 {
-		fmt.Println("Girya is a simple HTTP stress tester.\n")
-		fmt.Println("Usage: gyra [options] URL")
-		flag.PrintDefaults()
-		fmt.Println("")
-		os.Exit(0)
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = concurrencyLevel
+	}
+
+	var duration time.Duration
+	if durationFlag != "" {
+		var err error
+		duration, err = time.ParseDuration(durationFlag)
+		if err != nil {
+			fmt.Println("invalid -d duration:", err)
+			os.Exit(1)
+		}
+	}
+
+	client := newHTTPClient(requestTimeout, transportOptions{
+		maxIdleConnsPerHost: maxIdleConnsPerHost,
+		keepAlivesEnabled:   keepAlivesEnabled,
+		http2Enabled:        http2Enabled,
+		insecureSkipVerify:  insecureSkipVerify,
+	})
+
+	var requester Requester
+	var target string
+	if urlList != "" {
+		r, err := newWeightedURLRequester(client, urlList, method, contentType, http.Header(headers), thinkTime)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		requester = r
+		target = "url-list:" + urlList
+	} else {
+		// url is the first non flag argument. If none exists, print the usage and exit.
+		if len(flag.Args()) == 0 {
+			fmt.Println("Girya is a simple HTTP stress tester.\n")
+			fmt.Println("Usage: gyra [options] URL")
+			flag.PrintDefaults()
+			fmt.Println("")
+			os.Exit(0)
+		}
+		url := flag.Arg(0)
+		target = url
+
+		var body []byte
+		if bodyFile != "" {
+			b, err := ioutil.ReadFile(bodyFile)
+			if err != nil {
+				fmt.Println("failed to read -body file:", err)
+				os.Exit(1)
+			}
+			body = b
+		}
+
+		requester = &staticRequester{
+			client:      client,
+			method:      method,
+			url:         url,
+			body:        body,
+			contentType: contentType,
+			headers:     http.Header(headers),
+			thinkTime:   thinkTime,
+		}
 	}
-	url := flag.Arg(0)
 
 	// start the benchmark here
-	benchmark := NewBenchmarkStats(repetitions, concurrencyLevel)
+	benchmark := NewBenchmarkStats(repetitions, concurrencyLevel, keepRaw)
+	benchmark.targetRPS = targetRPS
+	benchmark.breakdownByStatus = breakdownByStatus
+	benchmark.breakdownByURL = breakdownByURL
+	benchmark.target = target
+	benchmark.concurrencyLevel = concurrencyLevel
+	benchmark.repetitions = repetitions
+	benchmark.durationFlag = durationFlag
+	if duration > 0 {
+		benchmark.deadline = benchmark.startedAt.Add(duration)
+	}
+
+	// stopCPUProfile stops and flushes the CPU profile, if one was
+	// started. It must be called explicitly before every os.Exit past
+	// this point: a deferred pprof.StopCPUProfile wouldn't run, since
+	// os.Exit skips deferred functions, and would leave the profile
+	// truncated on disk.
+	stopCPUProfile := func() {}
+	if cpuProfileFile != "" {
+		f, err := os.Create(cpuProfileFile)
+		if err != nil {
+			fmt.Println("failed to create -cpuprofile file:", err)
+			os.Exit(1)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Println("failed to start CPU profile:", err)
+			f.Close()
+			os.Exit(1)
+		}
+		stopCPUProfile = func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		}
+	}
+
+	pacer := newRequestPacer(targetRPS)
+	defer pacer.stop()
+
+	var progressDone chan struct{}
+	var progressWG sync.WaitGroup
+	if showProgress {
+		progressTotal := repetitions
+		if duration > 0 {
+			progressTotal = 0
+		}
+		progressDone = make(chan struct{})
+		progressWG.Add(1)
+		go func() {
+			defer progressWG.Done()
+			reportProgress(benchmark, progressTotal, progressDone)
+		}()
+	}
 
 	// start the given number of requests in parallel
 	for i := 0; i < concurrencyLevel; i += 1 {
-		benchmark.measureUrl(url)
+		benchmark.measure(requester, pacer)
 	}
 
-	for i := 0; i < repetitions; i += 1 {
-		// wait till a result arrives, process it and start a
-		// new worker if required.
-		benchmark.receiveResult()
-		if benchmark.requestsStarted < repetitions {
-			benchmark.measureUrl(url)
+	if duration > 0 {
+		// run until the deadline passes, rather than a fixed count.
+		// deadlineTimer bounds the wait even if a request hangs, since
+		// -timeout defaults to 0 (no per-request timeout).
+		deadlineTimer := time.NewTimer(time.Until(benchmark.deadline))
+		defer deadlineTimer.Stop()
+		for benchmark.receiveResultOrDeadline(deadlineTimer) && !benchmark.deadlineExceeded() {
+			benchmark.measure(requester, pacer)
+		}
+	} else {
+		for i := 0; i < repetitions; i += 1 {
+			// wait till a result arrives, process it and start a
+			// new worker if required.
+			benchmark.receiveResult()
+			if benchmark.requestsStarted < repetitions {
+				benchmark.measure(requester, pacer)
+			}
 		}
 	}
 
 	benchmark.stop()
+
+	if progressDone != nil {
+		close(progressDone)
+		progressWG.Wait()
+	}
+
+	if memProfileFile != "" {
+		f, err := os.Create(memProfileFile)
+		if err != nil {
+			fmt.Println("failed to create -memprofile file:", err)
+			stopCPUProfile()
+			os.Exit(1)
+		}
+		defer f.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			fmt.Println("failed to write heap profile:", err)
+			stopCPUProfile()
+			os.Exit(1)
+		}
+	}
+
 	benchmark.printStats()
+
+	if resultFile != "" {
+		if err := benchmark.writeResult(resultFile); err != nil {
+			fmt.Println("failed to write -resultFile:", err)
+			stopCPUProfile()
+			os.Exit(1)
+		}
+	}
+
+	stopCPUProfile()
 }
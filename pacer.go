@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// requestPacer throttles outgoing requests to a target rate. It hands
+// out one token per tick, so callers that wait() before dispatching a
+// request are spread evenly across the interval instead of bursting.
+// A nil *requestPacer is a valid, unthrottled pacer.
+type requestPacer struct {
+	ticker *time.Ticker
+}
+
+func newRequestPacer(targetRPS float64) *requestPacer {
+	if targetRPS <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / targetRPS)
+	// A -rps high enough to truncate interval to zero (or below) would
+	// otherwise panic time.NewTicker; clamp to the smallest tick
+	// instead, which paces as fast as a real clock can tell apart.
+	if interval < time.Nanosecond {
+		interval = time.Nanosecond
+	}
+	return &requestPacer{ticker: time.NewTicker(interval)}
+}
+
+func (p *requestPacer) wait() {
+	if p == nil {
+		return
+	}
+	<-p.ticker.C
+}
+
+func (p *requestPacer) stop() {
+	if p == nil {
+		return
+	}
+	p.ticker.Stop()
+}
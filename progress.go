@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// benchmarkSnapshot is a point-in-time, race-free view of a running
+// benchmark, returned by benchmarkStats.Snapshot().
+type benchmarkSnapshot struct {
+	completed int
+	errors    int
+	p50       time.Duration
+	p95       time.Duration
+}
+
+// Snapshot returns the current counters and latency percentiles under
+// the same lock recordResult uses, so reportProgress can read a
+// running benchmark from another goroutine without racing it.
+func (bm *benchmarkStats) Snapshot() benchmarkSnapshot {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return benchmarkSnapshot{
+		completed: bm.requestCount(),
+		errors:    bm.failedRequests,
+		p50:       bm.histogram.quantile(0.50),
+		p95:       bm.histogram.quantile(0.95),
+	}
+}
+
+// reportProgress prints a rolling one-line status to stderr every
+// tick until done is closed: completed/total, requests/sec over the
+// last tick, running p50/p95 latency, and the error count. total is 0
+// for -d runs, which have no fixed request count to report against.
+func reportProgress(bm *benchmarkStats, total int, done <-chan struct{}) {
+	const tickInterval = time.Second
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	previous := bm.Snapshot()
+	for {
+		select {
+		case <-done:
+			fmt.Fprintln(os.Stderr)
+			return
+		case <-ticker.C:
+			current := bm.Snapshot()
+			rps := float64(current.completed-previous.completed) / tickInterval.Seconds()
+			previous = current
+
+			if total > 0 {
+				fmt.Fprintf(os.Stderr, "\r%d/%d completed, %.0f req/s, p50 %s, p95 %s, %d errors",
+					current.completed, total, rps, current.p50, current.p95, current.errors)
+			} else {
+				fmt.Fprintf(os.Stderr, "\r%d completed, %.0f req/s, p50 %s, p95 %s, %d errors",
+					current.completed, rps, current.p50, current.p95, current.errors)
+			}
+		}
+	}
+}
+
+// stderrIsTerminal reports whether stderr looks like an interactive
+// terminal, used to pick -progress's default without an extra
+// dependency.
+func stderrIsTerminal() bool {
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return (info.Mode() & os.ModeCharDevice) != 0
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// requestTiming breaks total request latency down into the phases
+// httptrace.ClientTrace exposes. Phases are zero when a step was
+// skipped, e.g. dns/connect/tls on a reused keep-alive connection.
+type requestTiming struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+}
+
+// requestResult is what a Requester reports back for a single attempt.
+type requestResult struct {
+	url        string
+	statusCode int
+	replySize  int
+	timing     requestTiming
+}
+
+// Requester issues one HTTP request per call to Do and reports the
+// outcome. Implementations decide what gets sent: a fixed GET, a
+// POST/PUT with a body, a weighted replay of URLs read from a file,
+// and so on. The benchmark worker loop only ever talks to this
+// interface, so adding a new scenario never touches benchmarkStats.
+type Requester interface {
+	Do() requestResult
+}
+
+// doRequest builds and issues a single HTTP request, reading the full
+// response body so its size counts towards transferredBytes. It
+// reports status 500 locally (rather than panicking) on a failure to
+// build or send the request, so the worker loop keeps going; a failure
+// to read the body keeps the response's own status code.
+func doRequest(client *http.Client, method, url string, body []byte, contentType string, headers http.Header) requestResult {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		fmt.Println("failed to build request for", url, ":", err)
+		return requestResult{url: url, statusCode: 500}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for key, values := range headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	var timing requestTiming
+	var dnsStart, connectStart, tlsStart, sendStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { timing.dns = time.Since(dnsStart) },
+		ConnectStart:         func(network, addr string) { connectStart = time.Now() },
+		ConnectDone:          func(network, addr string, err error) { timing.connect = time.Since(connectStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { timing.tls = time.Since(tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { sendStart = time.Now() },
+		GotFirstResponseByte: func() { timing.ttfb = time.Since(sendStart) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("failed to fetch", url, ":", err)
+		return requestResult{url: url, statusCode: 500, timing: timing}
+	}
+	defer resp.Body.Close()
+
+	size := 0
+	for header, values := range resp.Header {
+		for _, value := range values {
+			size += len(header) + len(value)
+		}
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("failed to read body from", url, ":", err)
+		return requestResult{url: url, statusCode: resp.StatusCode, replySize: size, timing: timing}
+	}
+	size += len(respBody)
+
+	return requestResult{url: url, statusCode: resp.StatusCode, replySize: size, timing: timing}
+}
+
+// staticRequester repeatedly issues the same request: same method,
+// URL, body and headers every time.
+type staticRequester struct {
+	client      *http.Client
+	method      string
+	url         string
+	body        []byte
+	contentType string
+	headers     http.Header
+	thinkTime   time.Duration
+}
+
+func (r *staticRequester) Do() requestResult {
+	if r.thinkTime > 0 {
+		time.Sleep(r.thinkTime)
+	}
+	return doRequest(r.client, r.method, r.url, r.body, r.contentType, r.headers)
+}
+
+// weightedURL is one entry of a weightedURLRequester's target list.
+type weightedURL struct {
+	url    string
+	weight int
+}
+
+// weightedURLRequester replays a weighted list of URLs, similar to
+// replaying an access log: each Do() call picks one URL at random,
+// proportional to its weight.
+type weightedURLRequester struct {
+	client      *http.Client
+	method      string
+	contentType string
+	headers     http.Header
+	thinkTime   time.Duration
+	urls        []weightedURL
+	totalWeight int
+}
+
+// newWeightedURLRequester reads "<weight> <url>" lines from path
+// (weight defaults to 1 when omitted; blank lines and lines starting
+// with # are skipped).
+func newWeightedURLRequester(client *http.Client, path, method, contentType string, headers http.Header, thinkTime time.Duration) (*weightedURLRequester, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening url list %q: %v", path, err)
+	}
+	defer file.Close()
+
+	r := &weightedURLRequester{
+		client:      client,
+		method:      method,
+		contentType: contentType,
+		headers:     headers,
+		thinkTime:   thinkTime,
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		weight := 1
+		url := line
+		if fields := strings.Fields(line); len(fields) == 2 {
+			w, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("url list %q: invalid weight %q in line %q: %v", path, fields[0], line, err)
+			}
+			weight = w
+			url = fields[1]
+		}
+
+		r.urls = append(r.urls, weightedURL{url: url, weight: weight})
+		r.totalWeight += weight
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading url list %q: %v", path, err)
+	}
+	if len(r.urls) == 0 {
+		return nil, fmt.Errorf("url list %q contained no URLs", path)
+	}
+	if r.totalWeight <= 0 {
+		return nil, fmt.Errorf("url list %q has a total weight of %d, at least one URL must carry a positive weight", path, r.totalWeight)
+	}
+
+	return r, nil
+}
+
+func (r *weightedURLRequester) pick() string {
+	target := rand.Intn(r.totalWeight)
+	for _, wu := range r.urls {
+		target -= wu.weight
+		if target < 0 {
+			return wu.url
+		}
+	}
+	return r.urls[len(r.urls)-1].url
+}
+
+func (r *weightedURLRequester) Do() requestResult {
+	if r.thinkTime > 0 {
+		time.Sleep(r.thinkTime)
+	}
+	return doRequest(r.client, r.method, r.pick(), nil, r.contentType, r.headers)
+}
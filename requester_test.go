@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeURLList(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing url list: %v", err)
+	}
+	return path
+}
+
+func TestNewWeightedURLRequesterParsesWeightsAndURLs(t *testing.T) {
+	path := writeURLList(t, `
+# a comment, and a blank line above
+
+3 http://example.com/a
+http://example.com/b
+5 http://example.com/c
+`)
+
+	r, err := newWeightedURLRequester(nil, path, "GET", "", nil, 0)
+	if err != nil {
+		t.Fatalf("newWeightedURLRequester: %v", err)
+	}
+
+	want := []weightedURL{
+		{url: "http://example.com/a", weight: 3},
+		{url: "http://example.com/b", weight: 1},
+		{url: "http://example.com/c", weight: 5},
+	}
+	if len(r.urls) != len(want) {
+		t.Fatalf("got %d urls, want %d: %+v", len(r.urls), len(want), r.urls)
+	}
+	for i, w := range want {
+		if r.urls[i] != w {
+			t.Errorf("urls[%d] = %+v, want %+v", i, r.urls[i], w)
+		}
+	}
+	if r.totalWeight != 9 {
+		t.Errorf("totalWeight = %d, want 9", r.totalWeight)
+	}
+}
+
+func TestNewWeightedURLRequesterInvalidWeight(t *testing.T) {
+	path := writeURLList(t, "5x http://example.com\n")
+
+	_, err := newWeightedURLRequester(nil, path, "GET", "", nil, 0)
+	if err == nil {
+		t.Fatal("expected an error for an unparseable weight, got nil")
+	}
+}
+
+func TestNewWeightedURLRequesterZeroTotalWeight(t *testing.T) {
+	path := writeURLList(t, "0 http://example.com/a\n0 http://example.com/b\n")
+
+	_, err := newWeightedURLRequester(nil, path, "GET", "", nil, 0)
+	if err == nil {
+		t.Fatal("expected an error for a zero total weight, got nil")
+	}
+}
+
+func TestNewWeightedURLRequesterNoURLs(t *testing.T) {
+	path := writeURLList(t, "# just a comment\n\n")
+
+	_, err := newWeightedURLRequester(nil, path, "GET", "", nil, 0)
+	if err == nil {
+		t.Fatal("expected an error for an empty url list, got nil")
+	}
+}
+
+func TestWeightedURLRequesterPickDistribution(t *testing.T) {
+	path := writeURLList(t, "1 http://example.com/rare\n9 http://example.com/common\n")
+
+	r, err := newWeightedURLRequester(nil, path, "GET", "", nil, 0)
+	if err != nil {
+		t.Fatalf("newWeightedURLRequester: %v", err)
+	}
+
+	const trials = 10000
+	counts := make(map[string]int)
+	for i := 0; i < trials; i++ {
+		counts[r.pick()]++
+	}
+
+	if counts["http://example.com/rare"]+counts["http://example.com/common"] != trials {
+		t.Fatalf("pick returned an unexpected url, counts: %+v", counts)
+	}
+
+	// With a 1:9 weight split, "common" should land somewhere around
+	// 90% of picks; allow generous slack since this is a random draw.
+	gotFraction := float64(counts["http://example.com/common"]) / trials
+	if gotFraction < 0.8 || gotFraction > 0.98 {
+		t.Errorf("common url picked %.2f%% of the time, want roughly 90%%", gotFraction*100)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// version identifies the girya build, so a result file records what
+// produced it. It is empty unless set at build time with
+// -ldflags "-X main.version=<git describe/SHA>"; reading it from the
+// running binary rather than shelling out to git avoids stamping the
+// wrong SHA when girya is run from outside its source checkout.
+var version string
+
+// reportedQuantiles is the set of latency percentiles surfaced both
+// in printStats and in writeResult, so the two stay in sync.
+var reportedQuantiles = []float64{0.50, 0.75, 0.90, 0.95, 0.99, 0.999}
+
+// runParams captures the invocation so a result file is self
+// describing without the caller having to remember how it was run.
+type runParams struct {
+	Target           string  `json:"target"`
+	ConcurrencyLevel int     `json:"concurrency"`
+	Repetitions      int     `json:"repetitions"`
+	Duration         string  `json:"duration,omitempty"`
+	TargetRPS        float64 `json:"target_rps,omitempty"`
+	GiryaVersion     string  `json:"girya_version,omitempty"`
+}
+
+type percentileResult struct {
+	Quantile  float64 `json:"quantile"`
+	LatencyNs int64   `json:"latency_ns"`
+}
+
+// benchmarkResult is the JSON-serializable view of a completed run,
+// including its full latency distribution so two runs can be diffed
+// without re-running the benchmark.
+type benchmarkResult struct {
+	Params             runParams          `json:"params"`
+	SuccessfulRequests int                `json:"successful_requests"`
+	FailedRequests     int                `json:"failed_requests"`
+	TransferredBytes   int                `json:"transferred_bytes"`
+	ElapsedSeconds     float64            `json:"elapsed_seconds"`
+	AchievedRPS        float64            `json:"achieved_rps"`
+	FastestNs          int64              `json:"fastest_ns"`
+	SlowestNs          int64              `json:"slowest_ns"`
+	MeanNs             int64              `json:"mean_ns"`
+	Percentiles        []percentileResult `json:"percentiles"`
+}
+
+func (bm *benchmarkStats) toResult() benchmarkResult {
+	h := bm.histogram
+	percentiles := make([]percentileResult, 0, len(reportedQuantiles))
+	for _, q := range reportedQuantiles {
+		percentiles = append(percentiles, percentileResult{Quantile: q, LatencyNs: int64(h.quantile(q))})
+	}
+
+	return benchmarkResult{
+		Params: runParams{
+			Target:           bm.target,
+			ConcurrencyLevel: bm.concurrencyLevel,
+			Repetitions:      bm.repetitions,
+			Duration:         bm.durationFlag,
+			TargetRPS:        bm.targetRPS,
+			GiryaVersion:     version,
+		},
+		SuccessfulRequests: bm.successfulRequests,
+		FailedRequests:     bm.failedRequests,
+		TransferredBytes:   bm.transferredBytes,
+		ElapsedSeconds:     bm.elapsedTime().Seconds(),
+		AchievedRPS:        bm.achievedRPS(),
+		FastestNs:          int64(h.min),
+		SlowestNs:          int64(h.max),
+		MeanNs:             int64(h.mean()),
+		Percentiles:        percentiles,
+	}
+}
+
+// writeResult serializes the run to path as JSON, and alongside it to
+// path+".bench" as a testing.BenchmarkResult / benchstat-compatible
+// text line, so multiple runs can be diffed with benchstat.
+func (bm *benchmarkStats) writeResult(path string) error {
+	result := bm.toResult()
+
+	jsonBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling result: %v", err)
+	}
+	if err := ioutil.WriteFile(path, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("writing %q: %v", path, err)
+	}
+
+	benchResult := testing.BenchmarkResult{
+		N:     bm.successfulRequests,
+		T:     bm.elapsedTime(),
+		Bytes: int64(bm.transferredBytes),
+	}
+	line := fmt.Sprintf("BenchmarkGirya/c=%d-%d\t%s\n", bm.concurrencyLevel, bm.concurrencyLevel, benchResult.String())
+	if err := ioutil.WriteFile(path+".bench", []byte(line), 0644); err != nil {
+		return fmt.Errorf("writing %q: %v", path+".bench", err)
+	}
+
+	return nil
+}